@@ -18,32 +18,80 @@ package xgracefulstop
 import (
 	"context"
 	"log"
+	"net"
 	"net/http"
 	"os"
-	"os/signal"
+	"sync"
 	"sync/atomic"
-	"syscall"
 	"time"
+
+	"golang.org/x/net/netutil"
 )
 
 const DefaultTimeout = 5 * time.Second
 
+// DefaultShutdownTimeout is the upper bound given to srv.Shutdown to drain
+// in-flight requests before it is force-closed.
+const DefaultShutdownTimeout = 15 * time.Second
+
+// DefaultHijackedDrainTimeout is how long a forced server close waits before
+// giving up on connections http.Server itself can't track, such as
+// hijacked WebSocket connections.
+const DefaultHijackedDrainTimeout = DefaultTimeout
+
+// DefaultTerminateTimeout is the upper bound AddWorker-registered workers
+// are given to finish after stopChQ is closed.
+const DefaultTerminateTimeout = DefaultTimeout
+
+// DefaultStartupTimeout is the upper bound StartupBegin gives the process to
+// call StartupDone before startup is considered failed.
+const DefaultStartupTimeout = 30 * time.Second
+
 type signalCh chan os.Signal
 type StopCh chan struct{}
 
 type GS struct {
-	timeout time.Duration
-	stopChQ []StopCh
-	srv     *http.Server
-	signal  signalCh
-	close   StopCh
-	started	uint32
-	done    StopCh
+	shutdownTimeout        time.Duration
+	hijackedDrainTimeout   time.Duration
+	terminateTimeout       time.Duration
+	startupTimeout         time.Duration
+	startupOnce            sync.Once
+	startupDoneCh          StopCh
+	startupMu              sync.Mutex
+	startupBegun           bool
+	startupCtx             context.Context
+	startupCancel          context.CancelFunc
+	readyMu                sync.Mutex
+	readyCh                StopCh
+	stopChQ                []StopCh
+	workersMu              sync.Mutex
+	workersClosed          bool
+	workers                sync.WaitGroup
+	servers                []*http.Server
+	listenLimit            int
+	listenersMu            sync.Mutex
+	listeners              []net.Listener
+	listenCalls            int
+	signal                 signalCh
+	close                  StopCh
+	started                uint32
+	done                   StopCh
+	reloadHooks            []func() error
+	beforeShutdownHooks    []func()
+	shutdownInitiatedHooks []func()
 }
 
 func NewGS(cap int, timeout time.Duration) *GS {
-	return &GS{
-		timeout: timeout,
+	if timeout <= 0 {
+		timeout = DefaultTerminateTimeout
+	}
+
+	gs := &GS{
+		shutdownTimeout:      DefaultShutdownTimeout,
+		hijackedDrainTimeout: DefaultHijackedDrainTimeout,
+		terminateTimeout:     timeout,
+		startupTimeout:       DefaultStartupTimeout,
+		startupDoneCh:        make(StopCh),
 		// Package signal will not block sending to c: the caller must ensure
 		// that c has sufficient buffer space to keep up with the expected
 		// signal rate. For a channel used for notification of just one signal value,
@@ -54,22 +102,267 @@ func NewGS(cap int, timeout time.Duration) *GS {
 		started: 0,
 		done:    make(StopCh),
 	}
+
+	// ready by default: a caller that never opts into StartupBegin/StartupDone
+	// still gets a Ready() that reflects reality instead of reporting
+	// permanently unhealthy.
+	gs.setReady(true)
+
+	return gs
+}
+
+// SetStartupTimeout overrides the upper bound StartupBegin gives the process
+// to call StartupDone before startup is considered failed. Default is
+// DefaultStartupTimeout. Must be called before StartupBegin.
+func (gs *GS) SetStartupTimeout(d time.Duration) {
+	gs.startupTimeout = d
+}
+
+// StartupBegin marks the beginning of the startup phase and returns a
+// context that is canceled once StartupDone is called or the startup
+// timeout elapses, whichever comes first. It also flips Ready() back to the
+// not-ready state until StartupDone is called, since opting into a startup
+// phase means the process isn't actually ready yet despite NewGS's default.
+// Watch() will not wait for a shutdown signal until startup is done; if the
+// timeout elapses first, it proceeds straight to the shutdown path instead.
+// StartupBegin must be called (if at all) before Watch(), since watch()
+// reads the startup state once, as soon as it starts running.
+func (gs *GS) StartupBegin() context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), gs.startupTimeout)
+
+	gs.startupMu.Lock()
+	gs.startupCtx, gs.startupCancel, gs.startupBegun = ctx, cancel, true
+	gs.startupMu.Unlock()
+
+	gs.setReady(false)
+
+	return ctx
+}
+
+// StartupDone marks the process as ready, flips Ready() to the ready state,
+// and cancels the context returned by StartupBegin. Safe to call multiple
+// times; only the first call has an effect.
+func (gs *GS) StartupDone() {
+	gs.startupOnce.Do(func() {
+		gs.setReady(true)
+
+		close(gs.startupDoneCh)
+
+		if gs.startupCancel != nil {
+			gs.startupCancel()
+		}
+	})
+}
+
+// Ready returns a channel that is closed while the process is ready to serve
+// traffic: after StartupDone and before shutdown is initiated. Health/
+// readiness handlers should treat a receive on the returned channel as
+// "ready" and anything else (including a blocking receive) as "not ready".
+// Because readiness can flip back to false during drain, callers must call
+// Ready() again on every check rather than caching the channel.
+func (gs *GS) Ready() <-chan struct{} {
+	gs.readyMu.Lock()
+	defer gs.readyMu.Unlock()
+
+	return gs.readyCh
+}
+
+func (gs *GS) setReady(v bool) {
+	ch := make(StopCh)
+
+	if v {
+		close(ch)
+	}
+
+	gs.readyMu.Lock()
+	gs.readyCh = ch
+	gs.readyMu.Unlock()
+}
+
+// awaitStartup blocks until StartupDone is called or the startup timeout
+// elapses, returning false only in the timeout case. If StartupBegin was
+// never called, there is no startup phase to wait for and it returns true
+// immediately.
+func (gs *GS) awaitStartup() bool {
+	gs.startupMu.Lock()
+	begun, ctx := gs.startupBegun, gs.startupCtx
+	gs.startupMu.Unlock()
+
+	if !begun {
+		return true
+	}
+
+	select {
+	case <-gs.startupDoneCh:
+		return true
+	case <-ctx.Done():
+		log.Println("Startup timeout exceeded, proceeding to shutdown")
+		return false
+	}
+}
+
+// SetShutdownTimeout overrides the upper bound given to srv.Shutdown to
+// drain in-flight requests before it is force-closed. Default is
+// DefaultShutdownTimeout.
+func (gs *GS) SetShutdownTimeout(d time.Duration) {
+	gs.shutdownTimeout = d
+}
+
+// SetHijackedDrainTimeout overrides how long a forced server close waits
+// before giving up on connections http.Server can't track, such as
+// hijacked WebSocket connections. Default is DefaultHijackedDrainTimeout.
+func (gs *GS) SetHijackedDrainTimeout(d time.Duration) {
+	gs.hijackedDrainTimeout = d
+}
+
+// SetTerminateTimeout overrides the upper bound AddWorker-registered
+// workers are given to finish after stopChQ is closed. Default is
+// DefaultTerminateTimeout. A zero or negative d means wait for all workers
+// unbounded instead of giving up on a deadline.
+func (gs *GS) SetTerminateTimeout(d time.Duration) {
+	gs.terminateTimeout = d
+}
+
+// AddWorker registers a worker with the terminate-phase wait group and
+// returns the callback the worker must call when it's done. watch() waits
+// for all registered workers to call their done callback, bounded by the
+// terminate timeout, before considering shutdown complete. AddWorker should
+// be called before the terminate phase begins (i.e. before watch() closes
+// stopChQ); a call that loses the race against it is ignored, since handing
+// it to the already-in-progress wait group would risk a "WaitGroup is
+// reused before previous Wait has returned" panic.
+func (gs *GS) AddWorker() func() {
+	gs.workersMu.Lock()
+	defer gs.workersMu.Unlock()
+
+	if gs.workersClosed {
+		log.Println("AddWorker: called after the terminate phase has begun, ignoring")
+		return func() {}
+	}
+
+	gs.workers.Add(1)
+
+	var once sync.Once
+
+	return func() {
+		once.Do(gs.workers.Done)
+	}
+}
+
+func (gs *GS) waitWorkers() {
+	gs.workersMu.Lock()
+	gs.workersClosed = true
+	gs.workersMu.Unlock()
+
+	if gs.terminateTimeout <= 0 {
+		// no deadline: wait for every registered worker unconditionally
+		gs.workers.Wait()
+		return
+	}
+
+	done := make(StopCh)
+
+	go func() {
+		gs.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(gs.terminateTimeout):
+		log.Println("Terminate timeout exceeded, some workers may still be running")
+	}
+}
+
+// OnReload registers fn to be called whenever the process receives a reload
+// request (SIGHUP on Unix) without tearing down the server. OnReload may be
+// called multiple times; hooks run in registration order. Unsupported on
+// platforms without a native reload signal (e.g. Windows), where it is a no-op.
+func (gs *GS) OnReload(fn func() error) {
+	gs.reloadHooks = append(gs.reloadHooks, fn)
+}
+
+func (gs *GS) runReloadHooks() {
+	log.Println("Reloading...")
+
+	for _, fn := range gs.reloadHooks {
+		if err := fn(); err != nil {
+			log.Printf("Reload Error: %s", err.Error())
+		}
+	}
+}
+
+// BeforeShutdown registers fn to be called after a shutdown request has been
+// received but before srv.Shutdown is called, e.g. to drain queues or flip a
+// readiness probe to unhealthy. BeforeShutdown may be called multiple times;
+// hooks run in registration order.
+func (gs *GS) BeforeShutdown(fn func()) {
+	gs.beforeShutdownHooks = append(gs.beforeShutdownHooks, fn)
+}
+
+// ShutdownInitiated registers fn to be called right as shutdown begins, e.g.
+// to notify long-lived connection handlers such as WebSockets to reconnect.
+// ShutdownInitiated may be called multiple times; hooks run in registration
+// order.
+func (gs *GS) ShutdownInitiated(fn func()) {
+	gs.shutdownInitiatedHooks = append(gs.shutdownInitiatedHooks, fn)
+}
+
+func runHooks(hooks []func()) {
+	for _, fn := range hooks {
+		fn()
+	}
 }
 
 func (gs *GS) Add(ch StopCh) {
 	gs.stopChQ = append(gs.stopChQ, ch)
 }
 
-func (gs *GS) Server(srv *http.Server) {
-	gs.srv = srv
+// AddServer registers srv to be gracefully shut down by watch(). Multiple
+// servers (e.g. HTTP + HTTPS + an admin listener) may be registered; they are
+// all shut down concurrently under the same signal handler.
+func (gs *GS) AddServer(srv *http.Server) {
+	gs.servers = append(gs.servers, srv)
 }
 
 func (gs *GS) SetServerAndWatch(srv *http.Server) {
-	gs.Server(srv)
+	gs.AddServer(srv)
 
 	gs.Watch()
 }
 
+// SetListenLimit bounds the number of simultaneously accepted connections on
+// listeners created via ListenAndServe, using netutil.LimitListener.
+func (gs *GS) SetListenLimit(n int) {
+	gs.listenLimit = n
+}
+
+// ListenAndServe creates a listener for srv.Addr (optionally bounded by
+// SetListenLimit), registers srv with AddServer, and runs srv.Serve on the
+// listener. It blocks until the server stops, returning http.ErrServerClosed
+// on a graceful shutdown.
+func (gs *GS) ListenAndServe(srv *http.Server) error {
+	addr := srv.Addr
+
+	if addr == "" {
+		addr = ":http"
+	}
+
+	l, err := net.Listen("tcp", addr)
+
+	if err != nil {
+		return err
+	}
+
+	if gs.listenLimit > 0 {
+		l = netutil.LimitListener(l, gs.listenLimit)
+	}
+
+	gs.AddServer(srv)
+
+	return srv.Serve(l)
+}
+
 // @see http.Server.closeDoneChanLocked
 func (gs *GS) Break() {
 	select {
@@ -95,45 +388,69 @@ func (gs *GS) Watch() {
 	}
 }
 
-func (gs *GS) watch() {
-	// kill (no param) default send syscall.SIGTERM
-	// kill -2 is syscall.SIGINT
-	// kill -9 is syscall. SIGKILL but can"t be catch, so don't need add it
-	signal.Notify(gs.signal, syscall.SIGINT, syscall.SIGTERM)
+// shutdownServer gracefully shuts down a single registered server, falling
+// back to a forced Close on timeout.
+func (gs *GS) shutdownServer(srv *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), gs.shutdownTimeout)
 
-	// wait for signal or break
-	select {
-	case <-gs.signal:
-		// TODO synced close gs.close as in http.Server tracked listen socket done channel
-		break
-	case <-gs.close:
-		return
+	// ATN! in any case freeing context
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Server Shutdown Error: %s", err.Error())
+
+		if context.DeadlineExceeded == err {
+			log.Println("Forces server shutdown...")
+
+			if gs.hijackedDrainTimeout > 0 {
+				// give hijacked connections (e.g. WebSockets) a last
+				// chance to drain on their own before force-closing
+				time.Sleep(gs.hijackedDrainTimeout)
+			}
+
+			// force close hangs up server connections
+			// ATN! "Close does not attempt to close (and does not even know about)
+			//       any hijacked connections, such as WebSockets."
+			srv.Close()
+		}
 	}
+}
 
-	// fast link
-	srv := gs.srv
+func (gs *GS) watch() {
+	// refuse to watch for a shutdown signal until startup has finished;
+	// on a startup timeout, fail fast straight into the shutdown path below
+	if gs.awaitStartup() {
+		// platform-specific: OS signals (+ SIGHUP reload) on Unix, SCM
+		// control codes via svc.Run on Windows. Returns once a real
+		// shutdown request (signal, SCM stop, or Break()) is observed.
+		gs.watchSignal()
+	}
 
-	if srv != nil {
-		log.Println("Shutdown Server ...")
+	runHooks(gs.beforeShutdownHooks)
 
-		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	// not ready for new traffic from here on; drain until done closes
+	gs.setReady(false)
 
-		if err := srv.Shutdown(ctx); err != nil {
-			log.Printf("Server Shutdown Error: %s", err.Error())
+	runHooks(gs.shutdownInitiatedHooks)
 
-			if context.DeadlineExceeded == err {
-				log.Println("Forces server shutdown...")
-				// force close hangs up server connections
-				// ATN! "Close does not attempt to close (and does not even know about)
-				//       any hijacked connections, such as WebSockets."
-				srv.Close()
-			}
+	if n := len(gs.servers); n > 0 {
+		log.Println("Shutdown Server(s) ...")
+
+		var wg sync.WaitGroup
+
+		wg.Add(n)
+
+		for _, srv := range gs.servers {
+			go func(srv *http.Server) {
+				defer wg.Done()
+
+				gs.shutdownServer(srv)
+			}(srv)
 		}
 
-		log.Println("Server stopped")
+		wg.Wait()
 
-		// ATN! in any case freeing context
-		cancel()
+		log.Println("Server(s) stopped")
 	}
 
 	for _, ch := range gs.stopChQ {
@@ -143,10 +460,8 @@ func (gs *GS) watch() {
 		close(ch)
 	}
 
-	if (gs.timeout > 0) && (len(gs.stopChQ) > 0) {
-		time.Sleep(gs.timeout)
-	}
+	gs.waitWorkers()
 
 	// send signal "gs is finished"
 	close(gs.done)
-}
\ No newline at end of file
+}
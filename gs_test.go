@@ -0,0 +1,68 @@
+//  Copyright (C) 2019 - 2023 Illirgway
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package xgracefulstop
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWaitWorkersRespectsTerminateTimeout(t *testing.T) {
+	gs := NewGS(0, 20*time.Millisecond)
+
+	// register a worker that never signals done
+	gs.AddWorker()
+
+	start := time.Now()
+	gs.waitWorkers()
+	elapsed := time.Since(start)
+
+	if elapsed < 20*time.Millisecond {
+		t.Fatalf("waitWorkers returned after %v, before the terminate timeout elapsed", elapsed)
+	}
+}
+
+func TestWaitWorkersUnboundedWaitsForCompletion(t *testing.T) {
+	gs := NewGS(0, DefaultTimeout)
+	gs.SetTerminateTimeout(0)
+
+	done := gs.AddWorker()
+
+	var finished bool
+
+	go func() {
+		time.Sleep(15 * time.Millisecond)
+		finished = true
+		done()
+	}()
+
+	gs.waitWorkers()
+
+	if !finished {
+		t.Fatal("waitWorkers returned before the worker finished despite an unbounded (0) terminate timeout")
+	}
+}
+
+func TestAddWorkerAfterTerminatePhaseIsIgnored(t *testing.T) {
+	gs := NewGS(0, 10*time.Millisecond)
+
+	// no workers registered, so this closes the terminate phase immediately
+	gs.waitWorkers()
+
+	// must not panic with "WaitGroup is reused before previous Wait has returned"
+	done := gs.AddWorker()
+	done()
+}
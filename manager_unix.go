@@ -0,0 +1,58 @@
+//go:build !windows
+// +build !windows
+
+//  Copyright (C) 2019 - 2023 Illirgway
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package xgracefulstop
+
+import (
+	"os/signal"
+	"syscall"
+)
+
+// watchSignal blocks until a real shutdown request is observed: SIGINT,
+// SIGTERM, or Break(). SIGHUP is treated as a reload request and does not
+// cause watchSignal to return; it runs the registered OnReload hooks and
+// keeps waiting. SIGUSR2 triggers a graceful restart: a child process is
+// spawned inheriting the listeners tracked via Listen, and once the child
+// signals via NotifyBound that it has bound them, this process proceeds into
+// its own normal shutdown; if it does not, this process keeps waiting.
+func (gs *GS) watchSignal() {
+	// kill (no param) default send syscall.SIGTERM
+	// kill -2 is syscall.SIGINT
+	// kill -9 is syscall. SIGKILL but can"t be catch, so don't need add it
+	// kill -1 is syscall.SIGHUP, used here to trigger a config reload
+	// kill -12 is syscall.SIGUSR2, used here to trigger a graceful restart
+	signal.Notify(gs.signal, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR2)
+
+	for {
+		select {
+		case sig := <-gs.signal:
+			switch sig {
+			case syscall.SIGHUP:
+				gs.runReloadHooks()
+				continue
+			case syscall.SIGUSR2:
+				gs.restart()
+				continue
+			}
+
+			return
+		case <-gs.close:
+			return
+		}
+	}
+}
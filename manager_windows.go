@@ -0,0 +1,100 @@
+//go:build windows
+// +build windows
+
+//  Copyright (C) 2019 - 2023 Illirgway
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package xgracefulstop
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+// gsServiceHandler adapts GS to the Windows Service Control Manager,
+// translating SCM stop/shutdown control requests into a Break() call so
+// they follow the same graceful path as any other shutdown trigger.
+type gsServiceHandler struct {
+	gs *GS
+}
+
+func (h gsServiceHandler) Execute(args []string, r <-chan svc.ChangeRequest, s chan<- svc.Status) (svcSpecificEC bool, exitCode uint32) {
+	s <- svc.Status{State: svc.StartPending}
+	s <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+loop:
+	for {
+		select {
+		case <-h.gs.close:
+			break loop
+		case c := <-r:
+			switch c.Cmd {
+			case svc.Interrogate:
+				s <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				break loop
+			}
+		}
+	}
+
+	s <- svc.Status{State: svc.StopPending}
+
+	h.gs.Break()
+
+	// don't tell the SCM we've stopped until watch()'s graceful shutdown
+	// (srv.Shutdown, stopChQ, AddWorker workers, ...) has actually finished,
+	// otherwise the SCM may kill the process mid-drain
+	<-h.gs.done
+
+	s <- svc.Status{State: svc.Stopped}
+
+	return false, 0
+}
+
+// watchSignal blocks until a real shutdown request is observed. When running
+// under the Service Control Manager, stop/shutdown control codes are
+// dispatched via svc.Run; otherwise it falls back to watching for
+// os.Interrupt/SIGTERM like a normal console process. There is no SIGHUP on
+// Windows, so OnReload hooks are never invoked here.
+func (gs *GS) watchSignal() {
+	isSvc, err := svc.IsWindowsService()
+
+	if err != nil {
+		log.Printf("svc.IsWindowsService Error: %s", err.Error())
+	}
+
+	if isSvc {
+		go func() {
+			if err := svc.Run("", gsServiceHandler{gs: gs}); err != nil {
+				log.Printf("svc.Run Error: %s", err.Error())
+			}
+		}()
+
+		<-gs.close
+
+		return
+	}
+
+	signal.Notify(gs.signal, os.Interrupt, syscall.SIGTERM)
+
+	select {
+	case <-gs.signal:
+	case <-gs.close:
+	}
+}
@@ -0,0 +1,258 @@
+//go:build !windows
+// +build !windows
+
+//  Copyright (C) 2019 - 2023 Illirgway
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package xgracefulstop
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+// envListenFDs is the systemd-compatible env var a restarted child reads to
+// learn how many listening sockets were passed to it via ExtraFiles.
+const envListenFDs = "LISTEN_FDS"
+
+// envNotifyFD is the env var a restarted child reads to learn the fd of the
+// pipe it must write to (see NotifyBound) to tell the parent it has bound
+// successfully and it's safe for the parent to enter its own shutdown.
+const envNotifyFD = "XGRACEFULSTOP_NOTIFY_FD"
+
+// listenFDsStart is the first inherited fd number: 0, 1, 2 are always
+// stdin/stdout/stderr, so systemd/tylerb-style fd passing starts at 3.
+const listenFDsStart = 3
+
+type fileListener interface {
+	File() (*os.File, error)
+}
+
+// Listen creates a listener for network/addr, tracking it so a later
+// SIGUSR2-triggered restart can pass it down to the child process. If the
+// process was itself started with inherited listeners (LISTEN_FDS set by a
+// parent's restart), they are reconstructed in order instead of binding a
+// new socket.
+func (gs *GS) Listen(network, addr string) (net.Listener, error) {
+	if idx := gs.nextInheritedFD(); idx >= 0 {
+		f := os.NewFile(uintptr(listenFDsStart+idx), "")
+
+		l, err := net.FileListener(f)
+
+		f.Close()
+
+		if err == nil {
+			gs.trackListener(l)
+			return l, nil
+		}
+
+		log.Printf("Listen: failed to inherit fd %d: %s, falling back to a fresh listen", listenFDsStart+idx, err.Error())
+	}
+
+	l, err := net.Listen(network, addr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	gs.trackListener(l)
+
+	return l, nil
+}
+
+// nextInheritedFD returns the index (into the inherited fd range) of the
+// next listener to reconstruct, or -1 if there are no more inherited fds to
+// hand out. It is driven by how many times Listen has been called so far,
+// not by how many listeners ended up tracked, so a failed inherit that falls
+// back to a fresh net.Listen doesn't desync the fd numbering of later calls.
+func (gs *GS) nextInheritedFD() int {
+	n := listenFDsFromEnv()
+
+	if n <= 0 {
+		return -1
+	}
+
+	gs.listenersMu.Lock()
+	idx := gs.listenCalls
+	gs.listenCalls++
+	gs.listenersMu.Unlock()
+
+	if idx >= n {
+		return -1
+	}
+
+	return idx
+}
+
+func listenFDsFromEnv() int {
+	n, err := strconv.Atoi(os.Getenv(envListenFDs))
+
+	if err != nil {
+		return 0
+	}
+
+	return n
+}
+
+func (gs *GS) trackListener(l net.Listener) {
+	gs.listenersMu.Lock()
+	gs.listeners = append(gs.listeners, l)
+	gs.listenersMu.Unlock()
+}
+
+// restart spawns a copy of the running binary, passing the tracked
+// listeners down via ExtraFiles and LISTEN_FDS, and waits for the child to
+// signal via NotifyBound that it has bound them successfully before calling
+// Break() so this process enters its own graceful shutdown. If the child
+// dies before signaling, the restart is aborted and this process keeps
+// running on its existing listeners.
+func (gs *GS) restart() {
+	gs.listenersMu.Lock()
+	files := make([]*os.File, 0, len(gs.listeners))
+
+	for _, l := range gs.listeners {
+		f, err := listenerFile(l)
+
+		if err != nil {
+			log.Printf("restart: listener has no inheritable fd: %s", err.Error())
+			gs.listenersMu.Unlock()
+			closeFiles(files)
+			return
+		}
+
+		files = append(files, f)
+	}
+	gs.listenersMu.Unlock()
+
+	notifyR, notifyW, err := os.Pipe()
+
+	if err != nil {
+		log.Printf("restart: failed to create notify pipe: %s", err.Error())
+		closeFiles(files)
+		return
+	}
+
+	defer notifyR.Close()
+
+	notifyFD := listenFDsStart + len(files)
+	files = append(files, notifyW)
+
+	cmd := exec.Command(os.Args[0], os.Args[1:]...)
+
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("%s=%d", envListenFDs, len(files)-1),
+		fmt.Sprintf("%s=%d", envNotifyFD, notifyFD),
+	)
+
+	err = cmd.Start()
+
+	// the child now has its own dup of every fd in files (listeners +
+	// notifyW); the parent's copies, including notifyW, must be closed here
+	// regardless of outcome or they leak for the life of this process
+	closeFiles(files)
+
+	if err != nil {
+		log.Printf("restart: failed to spawn child: %s", err.Error())
+		return
+	}
+
+	if !gs.awaitChildBound(cmd, notifyR) {
+		log.Println("restart: child did not signal a successful bind, aborting restart")
+		return
+	}
+
+	log.Printf("restart: child pid %d bound successfully, entering graceful shutdown", cmd.Process.Pid)
+
+	gs.Break()
+}
+
+// awaitChildBound blocks until the child either writes to notifyR (a
+// successful NotifyBound call) or exits without ever doing so.
+func (gs *GS) awaitChildBound(cmd *exec.Cmd, notifyR *os.File) bool {
+	bound := make(chan bool, 1)
+
+	go func() {
+		buf := make([]byte, 1)
+		n, err := notifyR.Read(buf)
+		bound <- err == nil && n == 1
+	}()
+
+	exited := make(chan error, 1)
+
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	select {
+	case ok := <-bound:
+		return ok
+	case err := <-exited:
+		log.Printf("restart: child exited before signaling a bind: %v", err)
+		return false
+	}
+}
+
+// NotifyBound signals a restart()-spawned process's parent that this process
+// has finished rebinding its inherited listeners (via Listen) and is ready
+// to accept connections. It is a no-op if this process was not started via
+// restart(), so it's safe to call unconditionally, e.g. right after
+// StartupDone.
+func (gs *GS) NotifyBound() {
+	fdStr := os.Getenv(envNotifyFD)
+
+	if fdStr == "" {
+		return
+	}
+
+	fd, err := strconv.Atoi(fdStr)
+
+	if err != nil {
+		log.Printf("NotifyBound: invalid %s: %s", envNotifyFD, err.Error())
+		return
+	}
+
+	f := os.NewFile(uintptr(fd), "")
+
+	defer f.Close()
+
+	if _, err := f.Write([]byte{1}); err != nil {
+		log.Printf("NotifyBound: failed to signal parent: %s", err.Error())
+	}
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+func listenerFile(l net.Listener) (*os.File, error) {
+	fl, ok := l.(fileListener)
+
+	if !ok {
+		return nil, errors.New("listener does not support File()")
+	}
+
+	return fl.File()
+}
@@ -0,0 +1,139 @@
+//go:build !windows
+// +build !windows
+
+//  Copyright (C) 2019 - 2023 Illirgway
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package xgracefulstop
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestHelperProcess isn't a real test; it's a re-exec target other tests use
+// to exercise fd inheritance across an actual process boundary, since
+// LISTEN_FDS/ExtraFiles only take effect across exec, not by forging a fd in
+// the current process. It only acts when re-exec'd with
+// GO_WANT_HELPER_PROCESS=1 set; otherwise it's a no-op so `go test` still
+// reports it as an ordinary passing test.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+
+	defer os.Exit(0)
+
+	gs := NewGS(0, DefaultTimeout)
+
+	l, err := gs.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Listen failed: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	defer l.Close()
+
+	fmt.Fprintln(os.Stdout, l.Addr().String())
+}
+
+// TestListenReconstructsFromInheritedFD simulates a restart()-spawned child
+// by re-exec'ing this test binary with a listening socket passed down via
+// ExtraFiles and LISTEN_FDS set, the same way restart() hands listeners to
+// its child, and asserts the child's Listen reconstructs the inherited
+// socket instead of binding a fresh one.
+func TestListenReconstructsFromInheritedFD(t *testing.T) {
+	orig, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("failed to create the listener to inherit: %s", err.Error())
+	}
+
+	defer orig.Close()
+
+	origFile, err := orig.(fileListener).File()
+
+	if err != nil {
+		t.Fatalf("failed to get the listener's fd: %s", err.Error())
+	}
+
+	defer origFile.Close()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHelperProcess$")
+	cmd.Env = append(os.Environ(), "GO_WANT_HELPER_PROCESS=1", envListenFDs+"=1")
+	cmd.ExtraFiles = []*os.File{origFile}
+
+	out, err := cmd.CombinedOutput()
+
+	if err != nil {
+		t.Fatalf("helper process failed: %s\noutput:\n%s", err.Error(), out)
+	}
+
+	if got := strings.TrimSpace(string(out)); got != orig.Addr().String() {
+		t.Fatalf("helper reconstructed listener on %q, want %q", got, orig.Addr().String())
+	}
+}
+
+// TestListenFallsBackWhenNoInheritedFDs covers the common case of a fresh
+// start with no LISTEN_FDS set: Listen must bind normally rather than trying
+// to reconstruct a listener from a fd that was never passed down.
+func TestListenFallsBackWhenNoInheritedFDs(t *testing.T) {
+	os.Unsetenv(envListenFDs)
+
+	gs := NewGS(0, DefaultTimeout)
+
+	l, err := gs.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("Listen returned an error: %s", err.Error())
+	}
+
+	defer l.Close()
+
+	if len(gs.listeners) != 1 {
+		t.Fatalf("expected the freshly bound listener to be tracked, got %d tracked listeners", len(gs.listeners))
+	}
+}
+
+// TestNextInheritedFDDoesNotDesyncAfterFallback exercises the scenario the
+// chunk0-6 fix addressed: a failed inherit on an earlier Listen call must not
+// shift the fd numbering used by later, successful inherits.
+func TestNextInheritedFDDoesNotDesyncAfterFallback(t *testing.T) {
+	os.Setenv(envListenFDs, strconv.Itoa(2))
+	defer os.Unsetenv(envListenFDs)
+
+	gs := NewGS(0, DefaultTimeout)
+
+	// each call claims the next index whatever the caller then does with it,
+	// so a Listen whose inherit of index 0 fails and falls back to a fresh
+	// listen has still consumed index 0.
+	if idx := gs.nextInheritedFD(); idx != 0 {
+		t.Fatalf("expected the first call to claim inherited index 0, got %d", idx)
+	}
+
+	if idx := gs.nextInheritedFD(); idx != 1 {
+		t.Fatalf("expected the second call to claim inherited index 1, not re-claim index 0, got %d", idx)
+	}
+
+	if idx := gs.nextInheritedFD(); idx != -1 {
+		t.Fatalf("expected no more inherited fds once LISTEN_FDS is exhausted, got %d", idx)
+	}
+}
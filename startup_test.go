@@ -0,0 +1,85 @@
+//  Copyright (C) 2019 - 2023 Illirgway
+//
+//  This program is free software: you can redistribute it and/or modify
+//  it under the terms of the GNU General Public License as published by
+//  the Free Software Foundation, either version 3 of the License, or
+//  (at your option) any later version.
+//
+//  This program is distributed in the hope that it will be useful,
+//  but WITHOUT ANY WARRANTY; without even the implied warranty of
+//  MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+//  GNU General Public License for more details.
+//
+//  You should have received a copy of the GNU General Public License
+//  along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package xgracefulstop
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReadyDefaultsToReadyWithoutStartupPhase(t *testing.T) {
+	gs := NewGS(0, DefaultTimeout)
+
+	select {
+	case <-gs.Ready():
+	default:
+		t.Fatal("expected ready by default for a caller that never opts into StartupBegin/StartupDone")
+	}
+}
+
+func TestReadyTransitions(t *testing.T) {
+	gs := NewGS(0, DefaultTimeout)
+
+	gs.StartupBegin()
+
+	select {
+	case <-gs.Ready():
+		t.Fatal("expected not ready once a startup phase has begun and before StartupDone")
+	default:
+	}
+
+	gs.StartupDone()
+
+	select {
+	case <-gs.Ready():
+	default:
+		t.Fatal("expected ready after StartupDone")
+	}
+
+	// mirrors the flip watch() performs once shutdown is initiated
+	gs.setReady(false)
+
+	select {
+	case <-gs.Ready():
+		t.Fatal("expected not ready again once drain begins")
+	default:
+	}
+}
+
+func TestAwaitStartupTimesOut(t *testing.T) {
+	gs := NewGS(0, DefaultTimeout)
+	gs.SetStartupTimeout(10 * time.Millisecond)
+
+	ctx := gs.StartupBegin()
+
+	if gs.awaitStartup() {
+		t.Fatal("expected awaitStartup to report failure once the startup timeout elapsed")
+	}
+
+	select {
+	case <-ctx.Done():
+	default:
+		t.Fatal("expected the StartupBegin context to be canceled after the timeout")
+	}
+}
+
+func TestAwaitStartupTrueWithoutStartupBegin(t *testing.T) {
+	gs := NewGS(0, DefaultTimeout)
+
+	if !gs.awaitStartup() {
+		t.Fatal("expected awaitStartup to proceed immediately when StartupBegin was never called")
+	}
+}